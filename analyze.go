@@ -0,0 +1,233 @@
+package main
+
+// analyze.go walks a fetched page's HTML looking for more than just
+// <a href> links: other tag types that reference resources, inline CSS
+// url(...) references, and a handful of metadata fields that make the
+// crawl index actually useful for search rather than just a link graph.
+
+import (
+    "io"
+    "log"
+    "net/url"
+    "regexp"
+    "strings"
+
+    "golang.org/x/net/html"
+)
+
+// cssURLPattern extracts the URL out of a CSS url(...) reference, as
+// found in <style> blocks and style="..." attributes.
+var cssURLPattern = regexp.MustCompile(`url\(\s*["']?([^"'\)]+)["']?\s*\)`)
+
+// phoneNumberPattern matches tel: links found in an href.
+var phoneNumberPattern = regexp.MustCompile(`^tel://(.+)$`)
+
+// PageAnalysis is everything analyzePage extracts from a page's HTML.
+type PageAnalysis struct {
+    // Links are navigational (<a href>) targets the crawler follows.
+    Links []url.URL
+    // Assets are resources the page references but the crawler does not
+    // follow: stylesheets, images, scripts, frames and the like.
+    Assets       []url.URL
+    PhoneNumbers []string
+
+    Title           string
+    MetaDescription string
+    Canonical       *url.URL
+    Language        string
+}
+
+func getAttribute(token html.Token, name string) (ok bool, value string) {
+    for _, a := range token.Attr {
+        if a.Key == name {
+            value = a.Val
+            ok = true
+            break
+        }
+    }
+    return
+}
+
+func getHref(token html.Token) (ok bool, href string) {
+    return getAttribute(token, "href")
+}
+
+func getPhoneNumber(value string) (bool, string) {
+    results := phoneNumberPattern.FindStringSubmatch(value)
+    if results == nil {
+        return false, ""
+    }
+    return true, results[0]
+}
+
+// extractCSSUrls pulls every url(...) reference out of a blob of CSS,
+// whether that's the body of a <style> block or a style="..." attribute.
+func extractCSSUrls(css string) []string {
+    matches := cssURLPattern.FindAllStringSubmatch(css, -1)
+    urls := make([]string, 0, len(matches))
+    for _, m := range matches {
+        urls = append(urls, strings.TrimSpace(m[1]))
+    }
+    return urls
+}
+
+// assetTags maps tag names that reference a single-URL resource to the
+// attribute that holds it.
+var assetTags = map[string]string{
+    "img":    "src",
+    "script": "src",
+    "iframe": "src",
+}
+
+// analyzePage tokenizes a page's HTML relative to documentUrl, returning
+// its navigational links, referenced assets, and metadata.
+func analyzePage(documentUrl url.URL, r io.Reader) PageAnalysis {
+    z := html.NewTokenizer(r)
+    var analysis PageAnalysis
+    var err error
+
+    inHeader := false
+    inTitle := false
+    inStyle := false
+    var styleBuf strings.Builder
+
+    baseURL := new(url.URL)
+    *baseURL = documentUrl
+
+    resolve := func(raw string) (*url.URL, bool) {
+        u, err := resolveHref(*baseURL, raw)
+        if err != nil {
+            log.Println(err)
+            return nil, false
+        }
+        u.Fragment = ""
+        return u, true
+    }
+
+    addAssetsFromCSS := func(css string) {
+        for _, raw := range extractCSSUrls(css) {
+            if u, ok := resolve(raw); ok {
+                analysis.Assets = append(analysis.Assets, *u)
+            }
+        }
+    }
+
+    for {
+        tt := z.Next()
+        switch tt {
+        case html.ErrorToken:
+            return analysis
+        case html.TextToken:
+            if inTitle {
+                analysis.Title += string(z.Text())
+            }
+            if inStyle {
+                styleBuf.Write(z.Text())
+            }
+        case html.StartTagToken, html.SelfClosingTagToken:
+            t := z.Token()
+
+            if ok, style := getAttribute(t, "style"); ok {
+                addAssetsFromCSS(style)
+            }
+
+            switch t.Data {
+            case "head":
+                inHeader = true
+            case "html":
+                if ok, lang := getAttribute(t, "lang"); ok {
+                    analysis.Language = lang
+                }
+            case "title":
+                inTitle = true
+            case "style":
+                inStyle = true
+                styleBuf.Reset()
+            case "base":
+                if inHeader {
+                    if ok, rawBaseUrl := getAttribute(t, "href"); ok {
+                        baseURL, err = url.Parse(rawBaseUrl)
+                        if err != nil {
+                            log.Println("Invalid base tag: ", err)
+                            *baseURL = documentUrl
+                        }
+                    }
+                }
+            case "meta":
+                if ok, name := getAttribute(t, "name"); ok && strings.EqualFold(name, "description") {
+                    if ok, content := getAttribute(t, "content"); ok {
+                        analysis.MetaDescription = content
+                    }
+                }
+            case "link":
+                ok, rawUrl := getHref(t)
+                if !ok {
+                    continue
+                }
+                u, ok := resolve(rawUrl)
+                if !ok {
+                    continue
+                }
+                analysis.Assets = append(analysis.Assets, *u)
+                if ok, rel := getAttribute(t, "rel"); ok && strings.EqualFold(rel, "canonical") {
+                    analysis.Canonical = u
+                }
+            case "a":
+                ok, rawUrl := getHref(t)
+                if !ok {
+                    continue
+                }
+                if ok, phoneNumber := getPhoneNumber(rawUrl); ok {
+                    analysis.PhoneNumbers = append(analysis.PhoneNumbers, phoneNumber)
+                    continue
+                }
+                if u, ok := resolve(rawUrl); ok {
+                    analysis.Links = append(analysis.Links, *u)
+                }
+            case "source":
+                if ok, src := getAttribute(t, "src"); ok {
+                    if u, ok := resolve(src); ok {
+                        analysis.Assets = append(analysis.Assets, *u)
+                    }
+                }
+                if ok, srcset := getAttribute(t, "srcset"); ok {
+                    for _, candidate := range parseSrcset(srcset) {
+                        if u, ok := resolve(candidate); ok {
+                            analysis.Assets = append(analysis.Assets, *u)
+                        }
+                    }
+                }
+            default:
+                if attr, isAsset := assetTags[t.Data]; isAsset {
+                    if ok, raw := getAttribute(t, attr); ok {
+                        if u, ok := resolve(raw); ok {
+                            analysis.Assets = append(analysis.Assets, *u)
+                        }
+                    }
+                }
+            }
+        case html.EndTagToken:
+            t := z.Token()
+            switch t.Data {
+            case "title":
+                inTitle = false
+            case "style":
+                inStyle = false
+                addAssetsFromCSS(styleBuf.String())
+            }
+        }
+    }
+}
+
+// parseSrcset splits a srcset attribute ("a.jpg 1x, b.jpg 2x") into its
+// candidate URLs, discarding the width/density descriptors.
+func parseSrcset(srcset string) []string {
+    var urls []string
+    for _, candidate := range strings.Split(srcset, ",") {
+        fields := strings.Fields(strings.TrimSpace(candidate))
+        if len(fields) > 0 {
+            urls = append(urls, fields[0])
+        }
+    }
+    return urls
+}
@@ -0,0 +1,276 @@
+package main
+
+// frontier.go persists the crawl queue to disk so a long-running crawl
+// doesn't hold every pending/seen URL in RAM and can pick up where it
+// left off after a crash or a Ctrl-C.
+
+import (
+    "crypto/sha1"
+    "encoding/json"
+    "fmt"
+    "net/url"
+
+    "go.etcd.io/bbolt"
+)
+
+var (
+    queueBucket    = []byte("queue")
+    seenBucket     = []byte("seen")
+    metaBucket     = []byte("meta")
+    inFlightBucket = []byte("inflight")
+)
+
+// seedURLKey stores the URL the frontier was originally seeded with, so a
+// resumed crawl can tell whether a new CLI-supplied seed is being silently
+// ignored.
+var seedURLKey = []byte("seed-url")
+
+// Frontier is the durable queue of pending CrawJobs plus the set of URL
+// fingerprints already seen, so a resumed crawl neither loses pending
+// work nor re-visits pages it already queued.
+type Frontier interface {
+    // Push enqueues job unless its URL fingerprint has already been
+    // pushed before, in which case it reports enqueued=false.
+    Push(job CrawJob) (enqueued bool, err error)
+    // Pop moves the oldest pending job from the queue into an in-flight
+    // set and returns it. ok is false if the frontier currently has
+    // nothing pending. The job is not gone for good at this point: the
+    // caller must follow up with Complete once it has actually been
+    // fetched/stored, or Release if it wasn't (e.g. the crawl was
+    // cancelled first), otherwise it stays in the in-flight set and is
+    // requeued the next time the frontier is opened.
+    Pop() (job CrawJob, ok bool, err error)
+    // Complete permanently removes a job previously returned by Pop from
+    // the frontier, once it has been fetched (successfully or not).
+    Complete(job CrawJob) error
+    // Release requeues a job previously returned by Pop that was never
+    // actually fetched, so it is retried on a future Pop instead of being
+    // lost.
+    Release(job CrawJob) error
+    // Empty reports whether the frontier has never been seeded, which is
+    // how startCrawling decides whether to seed from the command line or
+    // resume an existing crawl. Unlike HasPending, this stays false once a
+    // crawl has been seeded even after every job has been popped, so a
+    // fully-drained frontier is still recognised as "already seeded".
+    Empty() (bool, error)
+    // SeedURL returns the URL the frontier was seeded with, and ok=false
+    // if it has never been seeded.
+    SeedURL() (u string, ok bool, err error)
+    // MarkSeeded records seed as the frontier's seed URL. Callers push the
+    // seed job before calling MarkSeeded.
+    MarkSeeded(seed string) error
+    // HasPending reports whether any job is currently queued (pushed but
+    // not yet popped), which startCrawling uses alongside in-flight jobs
+    // to detect when a crawl has run out of work.
+    HasPending() (bool, error)
+    Close() error
+}
+
+// BoltFrontier is a Frontier backed by a BoltDB (go.etcd.io/bbolt) file.
+type BoltFrontier struct {
+    db *bbolt.DB
+}
+
+// OpenFrontier opens (creating if necessary) the frontier database at
+// path. If the file already contains a frontier from a previous run,
+// the returned BoltFrontier resumes it. Any jobs left in-flight by a
+// previous run that crashed or was killed before calling Complete/Release
+// are moved back onto the queue, so a kill -9 loses at most the jobs that
+// were actually being fetched at the time, never ones merely dequeued.
+func OpenFrontier(path string) (*BoltFrontier, error) {
+    db, err := bbolt.Open(path, 0600, nil)
+    if err != nil {
+        return nil, fmt.Errorf("open frontier %q: %w", path, err)
+    }
+    err = db.Update(func(tx *bbolt.Tx) error {
+        if _, err := tx.CreateBucketIfNotExists(queueBucket); err != nil {
+            return err
+        }
+        if _, err := tx.CreateBucketIfNotExists(seenBucket); err != nil {
+            return err
+        }
+        if _, err := tx.CreateBucketIfNotExists(metaBucket); err != nil {
+            return err
+        }
+        inFlight, err := tx.CreateBucketIfNotExists(inFlightBucket)
+        if err != nil {
+            return err
+        }
+        return requeueInFlight(tx.Bucket(queueBucket), inFlight)
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &BoltFrontier{db: db}, nil
+}
+
+// requeueInFlight moves every job sitting in inFlight back onto queue,
+// preserving its original position in the queue order.
+func requeueInFlight(queue, inFlight *bbolt.Bucket) error {
+    c := inFlight.Cursor()
+    for key, value := c.First(); key != nil; key, value = c.Next() {
+        if err := queue.Put(key, value); err != nil {
+            return err
+        }
+        if err := c.Delete(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (f *BoltFrontier) Close() error {
+    return f.db.Close()
+}
+
+func (f *BoltFrontier) Empty() (bool, error) {
+    _, seeded, err := f.SeedURL()
+    return !seeded, err
+}
+
+func (f *BoltFrontier) SeedURL() (string, bool, error) {
+    var seed []byte
+    err := f.db.View(func(tx *bbolt.Tx) error {
+        seed = tx.Bucket(metaBucket).Get(seedURLKey)
+        return nil
+    })
+    if err != nil || seed == nil {
+        return "", false, err
+    }
+    return string(seed), true, nil
+}
+
+func (f *BoltFrontier) MarkSeeded(seed string) error {
+    return f.db.Update(func(tx *bbolt.Tx) error {
+        return tx.Bucket(metaBucket).Put(seedURLKey, []byte(seed))
+    })
+}
+
+func (f *BoltFrontier) HasPending() (bool, error) {
+    var pending bool
+    err := f.db.View(func(tx *bbolt.Tx) error {
+        c := tx.Bucket(queueBucket).Cursor()
+        if k, _ := c.First(); k != nil {
+            pending = true
+        }
+        return nil
+    })
+    return pending, err
+}
+
+// urlFingerprint is the seen-set key for u: a SHA1 digest of its
+// normalized string form, so equivalent URLs (different case, default
+// ports, tracking parameters, ...) share a fingerprint, and the digest
+// stays a fixed, compact size regardless of URL length.
+func urlFingerprint(u url.URL) []byte {
+    normalized := NormalizeURL(u)
+    sum := sha1.Sum([]byte(normalized.String()))
+    return sum[:]
+}
+
+type frontierJob struct {
+    URL   string
+    Level int
+}
+
+func (f *BoltFrontier) Push(job CrawJob) (bool, error) {
+    fingerprint := urlFingerprint(job.URL)
+    var enqueued bool
+    err := f.db.Update(func(tx *bbolt.Tx) error {
+        seen := tx.Bucket(seenBucket)
+        if seen.Get(fingerprint) != nil {
+            return nil
+        }
+        if err := seen.Put(fingerprint, []byte{}); err != nil {
+            return err
+        }
+
+        value, err := json.Marshal(frontierJob{URL: job.URL.String(), Level: job.Level})
+        if err != nil {
+            return err
+        }
+        queue := tx.Bucket(queueBucket)
+        seq, err := queue.NextSequence()
+        if err != nil {
+            return err
+        }
+        if err := queue.Put(sequenceKey(seq), value); err != nil {
+            return err
+        }
+        enqueued = true
+        return nil
+    })
+    return enqueued, err
+}
+
+func (f *BoltFrontier) Pop() (CrawJob, bool, error) {
+    var job CrawJob
+    var ok bool
+    err := f.db.Update(func(tx *bbolt.Tx) error {
+        queue := tx.Bucket(queueBucket)
+        c := queue.Cursor()
+        key, value := c.First()
+        if key == nil {
+            return nil
+        }
+
+        var fj frontierJob
+        if err := json.Unmarshal(value, &fj); err != nil {
+            return err
+        }
+        parsed, err := url.Parse(fj.URL)
+        if err != nil {
+            return err
+        }
+
+        inFlight := tx.Bucket(inFlightBucket)
+        if err := inFlight.Put(key, value); err != nil {
+            return err
+        }
+        if err := c.Delete(); err != nil {
+            return err
+        }
+
+        job = CrawJob{URL: *parsed, Level: fj.Level, frontierKey: append([]byte(nil), key...)}
+        ok = true
+        return nil
+    })
+    return job, ok, err
+}
+
+// Complete permanently removes job, previously returned by Pop, from the
+// in-flight set now that it has actually been fetched.
+func (f *BoltFrontier) Complete(job CrawJob) error {
+    if job.frontierKey == nil {
+        return nil
+    }
+    return f.db.Update(func(tx *bbolt.Tx) error {
+        return tx.Bucket(inFlightBucket).Delete(job.frontierKey)
+    })
+}
+
+// Release moves job, previously returned by Pop, back from the in-flight
+// set onto the queue, so it is popped again on a later call instead of
+// being lost.
+func (f *BoltFrontier) Release(job CrawJob) error {
+    if job.frontierKey == nil {
+        return nil
+    }
+    return f.db.Update(func(tx *bbolt.Tx) error {
+        inFlight := tx.Bucket(inFlightBucket)
+        value := inFlight.Get(job.frontierKey)
+        if value == nil {
+            return nil
+        }
+        value = append([]byte(nil), value...)
+        if err := tx.Bucket(queueBucket).Put(job.frontierKey, value); err != nil {
+            return err
+        }
+        return inFlight.Delete(job.frontierKey)
+    })
+}
+
+func sequenceKey(seq uint64) []byte {
+    return []byte(fmt.Sprintf("%020d", seq))
+}
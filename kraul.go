@@ -2,148 +2,43 @@ package main
 
 import (
     "bytes"
-    "encoding/json"
-    "errors"
+    "context"
+    "flag"
     "fmt"
     "io"
     "log"
     "net/http"
+    "net/http/httputil"
     "net/url"
     "os"
-    "path"
-    "regexp"
-    "strings"
+    "os/signal"
+    "sync/atomic"
+    "syscall"
     "time"
-
-    "golang.org/x/net/html"
 )
 
-func getAttribute(token html.Token, name string) (ok bool, value string) {
-    // Iterate over all of the Token's attributes until we find an "href"
-    for _, a := range token.Attr {
-        if a.Key == name {
-            value = a.Val
-            ok = true
-            break
-        }
-    }
-    return
-}
-
-// Helper function to pull the href attribute from a Token
-func getHref(token html.Token) (ok bool, href string) {
-    return getAttribute(token, "href")
-}
-
-func resolveHref(base url.URL, href string) (*url.URL, error) {
-    if base.Scheme == "" || base.Host == "" {
-        return nil, errors.New("Base URL has no scheme / host")
-    }
-    url, err := url.Parse(href)
-    if err != nil {
-        return nil, err
-    }
-    // log.Println(href)
-    switch url.Scheme {
-    case "":
-        url.Scheme = base.Scheme
-    case "http", "https", "ftp":
-
-    default:
-        return url, nil
-    }
-    if url.Host == "" {
-        url.Host = base.Host
-    }
-    if url.Path == "" {
-        if base.Path == "" {
-            url.Path = "/"
-        } else {
-            url.Path = base.Path
-        }
-    } else if !strings.HasPrefix(url.Path, "/") {
-        baseBasePath, _ := path.Split(base.Path)
-        url.Path = baseBasePath + url.Path
-    }
-    if err != nil {
-        return nil, err
-    }
-    // log.Println(url.String())
-    return url, nil
-}
-
-func getPhoneNumber(value string) (bool, string) {
-    phoneNumberRegex := regexp.MustCompile(`^tel://(.+)$`)
-    results := phoneNumberRegex.FindStringSubmatch(value)
-    if results == nil {
-        return false, ""
-    }
-    return true, results[0]
-}
-
-func extractLinks(documentUrl url.URL, r io.Reader) (links []url.URL, phoneNumbers []string) {
-    z := html.NewTokenizer(r)
-    var err error
-    inHeader := false
-    var baseURL = new(url.URL)
-    *baseURL = documentUrl
-    links = make([]url.URL, 0)
-    phoneNumbers = make([]string, 0)
-    for {
-        tt := z.Next()
-        switch {
-        case tt == html.ErrorToken:
-            // End of the document, we're done
-            return
-        case tt == html.StartTagToken:
-            t := z.Token()
-
-            switch t.Data {
-            case "head":
-                inHeader = true
-            case "base":
-                if inHeader {
-                    ok, rawBaseUrl := getAttribute(t, "href")
-                    if ok {
-                        baseURL, err = url.Parse(rawBaseUrl)
-                        if err != nil {
-                            log.Println("Invalid base tag: ", err)
-                            *baseURL = documentUrl
-                        }
-                    }
-                }
-            case "a":
-                // Extract the href value, if there is one
-                ok, rawUrl := getHref(t)
-                if !ok {
-                    continue
-                }
-
-                ok, phoneNumber := getPhoneNumber(rawUrl)
-                if ok {
-                    phoneNumbers = append(phoneNumbers, phoneNumber)
-                    continue
-                }
-
-                url, err := resolveHref(*baseURL, rawUrl)
-                if err != nil {
-                    log.Println(err)
-                    continue
-                }
-                // Make sure the url begines in http**
-                url.Fragment = ""
-                links = append(links, *url)
-            }
-
-        }
-    }
-}
-
 type IndexedWebPage struct {
-    URL          url.URL
-    Content      string
-    Links        []url.URL
-    PhoneNumbers []string
+    URL             url.URL
+    Content         string
+    Links           []url.URL
+    Assets          []url.URL
+    PhoneNumbers    []string
+    Title           string
+    MetaDescription string
+    Canonical       *url.URL
+    Language        string
+
+    // StatusCode, FetchedAt and the Raw* dumps preserve the wire
+    // representation of the exchange so it can be replayed into a WARC
+    // archive. They are populated by loadPage and are not otherwise used
+    // for link extraction.
+    StatusCode  int
+    FetchedAt   time.Time
+    RawRequest  []byte
+    RawResponse []byte
+
+    // Level is the number of hops this page is from the crawl seed.
+    Level int
 }
 
 type CrawlError struct {
@@ -154,46 +49,135 @@ type CrawlError struct {
 type CrawJob struct {
     URL   url.URL
     Level int
+
+    // frontierKey identifies this job in the frontier's in-flight set, so
+    // it can be passed back to Frontier.Complete/Release once the job has
+    // been (or failed to be) fetched. It is nil for jobs that didn't come
+    // from a Frontier.Pop, e.g. the initial seed job.
+    frontierKey []byte
 }
 
 // Extract all http** links from a given webpage
-func loadPage(url url.URL) (result *IndexedWebPage, err error) {
-    resp, err := http.Get(url.String())
+func loadPage(pageUrl url.URL, userAgent string) (result *IndexedWebPage, err error) {
+    req, err := http.NewRequest("GET", pageUrl.String(), nil)
+    if err != nil {
+        return
+    }
+    if userAgent != "" {
+        req.Header.Set("User-Agent", userAgent)
+    }
+    rawRequest, err := httputil.DumpRequestOut(req, false)
+    if err != nil {
+        return
+    }
+
+    fetchedAt := time.Now().UTC()
+    resp, err := http.DefaultClient.Do(req)
     if err != nil {
         return
     }
-    log.Printf("Loaded page %s\n", url.String())
+    log.Printf("Loaded page %s\n", pageUrl.String())
     b := resp.Body
     defer b.Close() // close Body when the function returns
     var buf bytes.Buffer
     reader := io.TeeReader(b, &buf)
-    links, phoneNumbers := extractLinks(url, reader)
-    log.Printf("Extracted Links %s\n", url.String())
+    analysis := analyzePage(pageUrl, reader)
+    log.Printf("Extracted Links %s\n", pageUrl.String())
+
+    // DumpResponse needs to read resp.Body itself, so hand it a fresh
+    // reader over the bytes we already buffered via the TeeReader above
+    // rather than consuming the real body twice.
+    resp.Body = io.NopCloser(bytes.NewReader(buf.Bytes()))
+    rawResponse, dumpErr := httputil.DumpResponse(resp, true)
+    if dumpErr != nil {
+        log.Println("Failed to dump response for WARC output:", dumpErr)
+    }
+
     result = &IndexedWebPage{
-        URL:          url,
-        Content:      buf.String(),
-        Links:        links,
-        PhoneNumbers: phoneNumbers,
+        URL:             pageUrl,
+        Content:         buf.String(),
+        Links:           analysis.Links,
+        Assets:          analysis.Assets,
+        PhoneNumbers:    analysis.PhoneNumbers,
+        Title:           analysis.Title,
+        MetaDescription: analysis.MetaDescription,
+        Canonical:       analysis.Canonical,
+        Language:        analysis.Language,
+        StatusCode:      resp.StatusCode,
+        FetchedAt:       fetchedAt,
+        RawRequest:      rawRequest,
+        RawResponse:     rawResponse,
     }
     return
 }
 
-func crawler(urlChannel chan url.URL, errorChannel chan CrawlError, crawlerId string, resultChannel chan IndexedWebPage) {
-    for url := range urlChannel {
-        log.Printf("[%s] Loading page %s\n", crawlerId, url.String())
-        result, err := loadPage(url)
-        log.Printf("Sending result... %s\n", url.String())
-        if err != nil {
-            errorChannel <- CrawlError{
-                Error: err,
-                URL:   url,
+// crawler pulls jobs off urlChannel until it is closed or ctx is done.
+// Once a job is popped off urlChannel it is always seen through to a
+// result or error send - startCrawling keeps receiving from those
+// channels for as long as inFlight is non-zero, so a blocking send here
+// can never outlive its receiver. Abandoning a job mid-flight instead
+// would leave inFlight permanently non-zero and startCrawling would never
+// return. A job is only frontier.Complete'd, permanently removing it from
+// the durable queue, once loadPage has actually run for it; this is what
+// lets a popped-but-not-yet-fetched job survive a crash or kill -9 (see
+// OpenFrontier). Every other way a job can leave here without being
+// fetched - ctx already done by the time it's popped, or still sitting in
+// urlChannel when shutdown starts - releases it back onto the frontier
+// instead, so graceful shutdown never silently drops buffered work.
+func crawler(ctx context.Context, urlChannel chan CrawJob, errorChannel chan CrawlError, crawlerId string, resultChannel chan IndexedWebPage, limiter *HostRateLimiter, userAgent string, inFlight *int64, frontier Frontier) {
+    for {
+        select {
+        case <-ctx.Done():
+            drainUrlChannel(urlChannel, inFlight, frontier)
+            return
+        case job, ok := <-urlChannel:
+            if !ok {
+                return
+            }
+            limiter.Wait(ctx, job.URL.Hostname())
+            if ctx.Err() != nil {
+                releaseJob(frontier, job)
+                errorChannel <- CrawlError{Error: ctx.Err(), URL: job.URL}
+                continue
+            }
+            log.Printf("[%s] Loading page %s\n", crawlerId, job.URL.String())
+            result, err := loadPage(job.URL, userAgent)
+            log.Printf("Sending result... %s\n", job.URL.String())
+            if completeErr := frontier.Complete(job); completeErr != nil {
+                log.Println("Frontier complete failed:", completeErr)
+            }
+            if err != nil {
+                errorChannel <- CrawlError{Error: err, URL: job.URL}
+            } else {
+                result.Level = job.Level
+                resultChannel <- *result
             }
-        } else {
-            resultChannel <- *result
         }
-        time.Sleep(100 * time.Millisecond)
     }
+}
 
+// releaseJob requeues job onto frontier so a job popped off the durable
+// queue but abandoned before being fetched isn't lost for good.
+func releaseJob(frontier Frontier, job CrawJob) {
+    if err := frontier.Release(job); err != nil {
+        log.Println("Frontier release failed:", err)
+    }
+}
+
+// drainUrlChannel discards any jobs already sitting in urlChannel's buffer,
+// releasing each back onto frontier's durable queue so it isn't lost, and
+// accounts for them so a job feedFrontier counted as in flight before ctx
+// was cancelled doesn't leave inFlight stuck above zero forever.
+func drainUrlChannel(urlChannel chan CrawJob, inFlight *int64, frontier Frontier) {
+    for {
+        select {
+        case job := <-urlChannel:
+            releaseJob(frontier, job)
+            atomic.AddInt64(inFlight, -1)
+        default:
+            return
+        }
+    }
 }
 
 func isWebUrl(url url.URL) bool {
@@ -203,90 +187,275 @@ func isWebUrl(url url.URL) bool {
     return false
 }
 
-func startCrawling(startUrl url.URL, resultChannel chan IndexedWebPage) {
-    urlChannel := make(chan url.URL, 10000)
+// feedFrontier pulls jobs from the durable frontier into the bounded
+// in-memory channel the worker pool reads from, so the workers don't need
+// to know the frontier is there at all. It stops popping new jobs as soon
+// as ctx is done, leaving anything still queued for the next run. inFlight
+// is incremented as each job is handed off so startCrawling can tell when
+// every popped job has been accounted for.
+func feedFrontier(ctx context.Context, frontier Frontier, urlChannel chan CrawJob, inFlight *int64) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        default:
+        }
+
+        job, ok, err := frontier.Pop()
+        if err != nil {
+            log.Println("Frontier pop failed:", err)
+            select {
+            case <-time.After(time.Second):
+            case <-ctx.Done():
+                return
+            }
+            continue
+        }
+        if !ok {
+            select {
+            case <-time.After(200 * time.Millisecond):
+            case <-ctx.Done():
+                return
+            }
+            continue
+        }
+
+        atomic.AddInt64(inFlight, 1)
+        select {
+        case urlChannel <- job:
+        case <-ctx.Done():
+            releaseJob(frontier, job)
+            atomic.AddInt64(inFlight, -1)
+            return
+        }
+    }
+}
+
+// startCrawling runs the BFS crawl from startUrl, or resumes one already
+// recorded in frontier. maxDepth caps how many hops from the seed a page
+// may be found at before its own links stop being enqueued; a negative
+// maxDepth means unlimited. robots gates every URL before it is pushed
+// onto the frontier, and limiter paces requests per host, honoring any
+// Crawl-delay robots.txt advertises.
+//
+// startCrawling returns, closing resultChannel, once there is no more work
+// to do: either the frontier and every in-flight job have been drained, or
+// ctx was cancelled (by a signal, or by maxPages being reached) and every
+// job already in flight has finished - whatever is still queued in the
+// frontier at that point is left for the next run to resume. cancel is
+// called internally once maxPages successfully crawled pages have been
+// stored, so the same drain-and-stop path handles both cases.
+func startCrawling(ctx context.Context, cancel context.CancelFunc, startUrl url.URL, resultChannel chan IndexedWebPage, scope Scope, maxDepth int, robots *RobotsCache, limiter *HostRateLimiter, userAgent string, frontier Frontier, maxPages int) {
+    defer close(resultChannel)
+
+    urlChannel := make(chan CrawJob, 10000)
     errorChannel := make(chan CrawlError)
     intermediateResultChannel := make(chan IndexedWebPage)
-    foundUrls := make(map[string]bool)
+    var inFlight int64
     for i := 0; i < 5; i++ {
-        go crawler(urlChannel, errorChannel, fmt.Sprintf("Crawler %d", i), intermediateResultChannel)
+        go crawler(ctx, urlChannel, errorChannel, fmt.Sprintf("Crawler %d", i), intermediateResultChannel, limiter, userAgent, &inFlight, frontier)
+    }
+
+    push := func(job CrawJob) bool {
+        if ctx.Err() != nil {
+            return false
+        }
+        if !robots.Allowed(job.URL) {
+            return false
+        }
+        if delay := robots.CrawlDelay(job.URL); delay > 0 {
+            limiter.SetDelay(job.URL.Hostname(), delay)
+        }
+        enqueued, err := frontier.Push(job)
+        if err != nil {
+            log.Println("Frontier push failed:", err)
+            return false
+        }
+        return enqueued
+    }
+
+    empty, err := frontier.Empty()
+    if err != nil {
+        log.Fatalln(err)
+    }
+    if empty {
+        if !push(CrawJob{URL: startUrl, Level: 0}) {
+            log.Printf("Seed URL %s disallowed by robots.txt\n", startUrl.String())
+            return
+        }
+        if err := frontier.MarkSeeded(startUrl.String()); err != nil {
+            log.Fatalln("Frontier MarkSeeded failed:", err)
+        }
+    } else {
+        seed, _, err := frontier.SeedURL()
+        if err != nil {
+            log.Fatalln(err)
+        }
+        if seed != startUrl.String() {
+            log.Fatalf("Frontier already seeded with %s; refusing to silently ignore the new seed %s. Pass -frontier pointing at a fresh path to start a new crawl.\n", seed, startUrl.String())
+        }
+        log.Println("Resuming crawl from existing frontier")
+    }
+
+    go feedFrontier(ctx, frontier, urlChannel, &inFlight)
+
+    // done reports whether the crawl has nothing left to do: no job in
+    // flight, and either the frontier is empty (a natural finish) or ctx
+    // has already been cancelled, in which case any still-queued work is
+    // simply left for the next run rather than waited out.
+    done := func() bool {
+        if atomic.LoadInt64(&inFlight) != 0 {
+            return false
+        }
+        if ctx.Err() != nil {
+            return true
+        }
+        pending, err := frontier.HasPending()
+        if err != nil {
+            log.Println("Frontier HasPending failed:", err)
+            return false
+        }
+        return !pending
     }
 
-    var urlsCounter int = 1
-    urlChannel <- startUrl
+    var pagesStored int
+    pollShutdown := time.NewTicker(500 * time.Millisecond)
+    defer pollShutdown.Stop()
+
     for {
         select {
+        case <-ctx.Done():
+            if done() {
+                return
+            }
         case errorResult := <-errorChannel:
-            urlsCounter--
+            atomic.AddInt64(&inFlight, -1)
             log.Printf("Error    %30s - %s\n", errorResult.URL.String(), errorResult.Error.Error())
+            if done() {
+                return
+            }
         case result := <-intermediateResultChannel:
-            urlsCounter--
+            atomic.AddInt64(&inFlight, -1)
             log.Printf("Spidered %30s - Links %d", result.URL.String(), len(result.Links))
             resultChannel <- result
+            pagesStored++
             fmt.Print(".")
-            for _, url := range result.Links {
-                if isWebUrl(url) {
-                    url.Fragment = ""
-                    urlString := url.String()
-                    if !foundUrls[urlString] {
-                        urlChannel <- url
-                        fmt.Print("<")
-                        urlsCounter++
-                        foundUrls[urlString] = true
+            if maxDepth < 0 || result.Level < maxDepth {
+                for _, url := range result.Links {
+                    if isWebUrl(url) && scope.InScope(url) {
+                        url.Fragment = ""
+                        if push(CrawJob{URL: url, Level: result.Level + 1}) {
+                            fmt.Print("<")
+                        }
                     }
                 }
             }
             fmt.Print("\n")
+            if maxPages > 0 && pagesStored >= maxPages {
+                log.Printf("Reached -max-pages %d, draining in-flight requests\n", maxPages)
+                cancel()
+            }
+            if done() {
+                return
+            }
+        case <-pollShutdown.C:
+            if done() {
+                return
+            }
         }
     }
-
 }
 
-func storeResult(result IndexedWebPage) error {
-    body, err := json.Marshal(result)
-    if err != nil {
-        return err
-    }
-    req, err := http.NewRequest("PUT", "http://localhost:9200/text/article/"+url.PathEscape(result.URL.String()), bytes.NewReader(body))
-    if err != nil {
-        return err
-    }
-
-    req.Header.Set("Content-Type", "application/json")
-
-    client := &http.Client{}
-    callResult, err := client.Do(req)
-    if err != nil {
-        return err
+func main() {
+    sinkKinds := flag.String("sink", "es", "comma-separated list of result sinks to write to: es, jsonl, warc, stdout")
+    esUrl := flag.String("es-url", "http://localhost:9200", "Elasticsearch base URL, used by the es sink")
+    esIndex := flag.String("es-index", "text", "Elasticsearch index name, used by the es sink")
+    esUsername := flag.String("es-username", "", "Elasticsearch basic auth username, used by the es sink")
+    esPassword := flag.String("es-password", "", "Elasticsearch basic auth password, used by the es sink")
+    jsonlPath := flag.String("jsonl-output", "crawl.jsonl", "file to append results to, used by the jsonl sink")
+    outputPath := flag.String("output", "crawl.warc.gz", "gzipped WARC file to write, used by the warc sink")
+    scopeKind := flag.String("scope", "", "restrict crawling to the seed's scope: host, domain, prefix, regex, or none (default)")
+    scopePrefix := flag.String("prefix", "", "URL prefix required to stay in scope, used by -scope=prefix (defaults to the seed URL)")
+    scopePattern := flag.String("pattern", "", "regex a URL must match to stay in scope, used by -scope=regex")
+    var includePatterns, excludePatterns stringSliceFlag
+    flag.Var(&includePatterns, "include", "only crawl URLs matching this regex (may be repeated; any match admits)")
+    flag.Var(&excludePatterns, "exclude", "never crawl URLs matching this regex (may be repeated)")
+    depth := flag.Int("depth", -1, "maximum link depth to follow from the seed URL (-1 for unlimited)")
+    userAgent := flag.String("user-agent", "kraul", "User-Agent sent with requests and matched against robots.txt groups")
+    crawlDelay := flag.Duration("crawl-delay", time.Second, "default minimum delay between requests to the same host, overridden by a host's robots.txt Crawl-delay")
+    frontierPath := flag.String("frontier", "kraul-frontier.db", "path to the on-disk frontier database; an existing one is resumed automatically")
+    maxPages := flag.Int("max-pages", 0, "stop after storing this many pages, draining in-flight requests first (0 for unlimited)")
+    flag.Parse()
+
+    if flag.NArg() < 1 {
+        fmt.Println("usage:", os.Args[0], "[flags] <start-url>")
+        return
     }
-    log.Println(callResult)
-    return nil
-}
 
-func main() {
-    foundUrls := make(map[string]bool)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+    go func() {
+        sig := <-signals
+        log.Printf("Received %s, draining in-flight requests...\n", sig)
+        cancel()
+    }()
 
     // Channels
     chResponses := make(chan IndexedWebPage)
 
-    url, err := url.Parse(os.Args[1])
+    url, err := url.Parse(flag.Arg(0))
     if err != nil {
         fmt.Println(err)
         return
     }
 
-    go startCrawling(*url, chResponses)
+    sink, err := NewResultSink(*sinkKinds, SinkConfig{
+        ElasticsearchURL:      *esUrl,
+        ElasticsearchIndex:    *esIndex,
+        ElasticsearchUsername: *esUsername,
+        ElasticsearchPassword: *esPassword,
+        JSONLinesPath:         *jsonlPath,
+        WARCPath:              *outputPath,
+    })
+    if err != nil {
+        log.Fatalln(err)
+    }
+    defer sink.Close()
 
-    for result := range chResponses {
-        log.Printf("\nResult %s", result.URL)
-        if err := storeResult(result); err != nil {
+    scope, err := NewScope(*scopeKind, *url, *scopePrefix, *scopePattern)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    if len(includePatterns) > 0 || len(excludePatterns) > 0 {
+        include, err := compileRegexps(includePatterns)
+        if err != nil {
+            log.Fatalln(err)
+        }
+        exclude, err := compileRegexps(excludePatterns)
+        if err != nil {
             log.Fatalln(err)
         }
+        scope = &IncludeExcludeScope{Base: scope, Include: include, Exclude: exclude}
     }
 
-    fmt.Println("\nFound", len(foundUrls), "unique urls:\n")
+    robots := NewRobotsCache(*userAgent)
+    limiter := NewHostRateLimiter(*crawlDelay)
+
+    frontier, err := OpenFrontier(*frontierPath)
+    if err != nil {
+        log.Fatalln(err)
+    }
+    defer frontier.Close()
 
-    for url := range foundUrls {
-        fmt.Println(url)
+    go startCrawling(ctx, cancel, *url, chResponses, scope, *depth, robots, limiter, *userAgent, frontier, *maxPages)
+
+    for result := range chResponses {
+        log.Printf("\nResult %s", result.URL)
+        if err := sink.Store(ctx, result); err != nil {
+            log.Println("Failed to store result:", err)
+        }
     }
+    log.Println("Crawl finished")
 }
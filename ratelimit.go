@@ -0,0 +1,67 @@
+package main
+
+// ratelimit.go paces requests per host, so crawling many hosts in
+// parallel isn't throttled by a single global sleep, while a single slow
+// (or robots-restricted) host is still spaced out correctly.
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// HostRateLimiter enforces a minimum delay between requests to the same
+// host. The delay defaults to defaultDelay but can be overridden per host,
+// e.g. from that host's robots.txt Crawl-delay.
+type HostRateLimiter struct {
+    defaultDelay time.Duration
+
+    mu    sync.Mutex
+    next  map[string]time.Time
+    delay map[string]time.Duration
+}
+
+func NewHostRateLimiter(defaultDelay time.Duration) *HostRateLimiter {
+    return &HostRateLimiter{
+        defaultDelay: defaultDelay,
+        next:         make(map[string]time.Time),
+        delay:        make(map[string]time.Duration),
+    }
+}
+
+// SetDelay overrides the minimum delay between requests to host.
+func (l *HostRateLimiter) SetDelay(host string, delay time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.delay[host] = delay
+}
+
+// Wait blocks the calling goroutine until it is host's turn to be fetched
+// again, or ctx is done first, so a large Crawl-delay doesn't stall
+// shutdown: the caller should check ctx.Err() after Wait returns rather
+// than assuming the delay fully elapsed.
+func (l *HostRateLimiter) Wait(ctx context.Context, host string) {
+    l.mu.Lock()
+    delay, ok := l.delay[host]
+    if !ok {
+        delay = l.defaultDelay
+    }
+    now := time.Now()
+    next := l.next[host]
+    if next.Before(now) {
+        next = now
+    }
+    wait := next.Sub(now)
+    l.next[host] = next.Add(delay)
+    l.mu.Unlock()
+
+    if wait <= 0 {
+        return
+    }
+    timer := time.NewTimer(wait)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+    case <-ctx.Done():
+    }
+}
@@ -0,0 +1,152 @@
+package main
+
+// robots.go fetches and caches robots.txt per host so the crawler honors
+// Disallow rules and a Crawl-delay before repeatedly hitting a host.
+
+import (
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// RobotsRules is the parsed subset of a robots.txt this crawler cares
+// about: the disallowed path prefixes for its user agent group, and an
+// optional crawl delay.
+type RobotsRules struct {
+    Disallow   []string
+    CrawlDelay time.Duration
+}
+
+func (r *RobotsRules) allowedPath(path string) bool {
+    for _, prefix := range r.Disallow {
+        if prefix != "" && strings.HasPrefix(path, prefix) {
+            return false
+        }
+    }
+    return true
+}
+
+// RobotsCache fetches each host's robots.txt at most once and serves the
+// parsed rules from memory afterwards.
+type RobotsCache struct {
+    userAgent string
+    client    *http.Client
+
+    mu    sync.Mutex
+    rules map[string]*RobotsRules
+}
+
+func NewRobotsCache(userAgent string) *RobotsCache {
+    return &RobotsCache{
+        userAgent: userAgent,
+        client:    &http.Client{Timeout: 10 * time.Second},
+        rules:     make(map[string]*RobotsRules),
+    }
+}
+
+// Allowed reports whether u may be fetched according to its host's
+// robots.txt.
+func (c *RobotsCache) Allowed(u url.URL) bool {
+    return c.rulesFor(u).allowedPath(u.Path)
+}
+
+// CrawlDelay returns the Crawl-delay u's host advertises, or zero if it
+// doesn't set one.
+func (c *RobotsCache) CrawlDelay(u url.URL) time.Duration {
+    return c.rulesFor(u).CrawlDelay
+}
+
+func (c *RobotsCache) rulesFor(u url.URL) *RobotsRules {
+    origin := u.Scheme + "://" + u.Host
+
+    c.mu.Lock()
+    rules, ok := c.rules[origin]
+    c.mu.Unlock()
+    if ok {
+        return rules
+    }
+
+    rules = c.fetch(origin)
+    c.mu.Lock()
+    c.rules[origin] = rules
+    c.mu.Unlock()
+    return rules
+}
+
+func (c *RobotsCache) fetch(origin string) *RobotsRules {
+    resp, err := c.client.Get(origin + "/robots.txt")
+    if err != nil {
+        return &RobotsRules{}
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return &RobotsRules{}
+    }
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return &RobotsRules{}
+    }
+    return parseRobotsTxt(string(body), c.userAgent)
+}
+
+// parseRobotsTxt implements the handful of directives this crawler needs:
+// User-agent grouping, Disallow and Crawl-delay. If there's no group
+// matching userAgent, the "*" group is used instead.
+func parseRobotsTxt(body string, userAgent string) *RobotsRules {
+    var (
+        ours       RobotsRules
+        wildcard   RobotsRules
+        inOurGroup bool
+        inWildcard bool
+        sawOurs    bool
+    )
+
+    for _, line := range strings.Split(body, "\n") {
+        if i := strings.IndexByte(line, '#'); i >= 0 {
+            line = line[:i]
+        }
+        line = strings.TrimSpace(line)
+        if line == "" {
+            continue
+        }
+        key, value, ok := strings.Cut(line, ":")
+        if !ok {
+            continue
+        }
+        key = strings.ToLower(strings.TrimSpace(key))
+        value = strings.TrimSpace(value)
+
+        switch key {
+        case "user-agent":
+            inWildcard = value == "*"
+            inOurGroup = strings.EqualFold(value, userAgent)
+            sawOurs = sawOurs || inOurGroup
+        case "disallow":
+            if inOurGroup {
+                ours.Disallow = append(ours.Disallow, value)
+            } else if inWildcard {
+                wildcard.Disallow = append(wildcard.Disallow, value)
+            }
+        case "crawl-delay":
+            seconds, err := strconv.ParseFloat(value, 64)
+            if err != nil {
+                continue
+            }
+            delay := time.Duration(seconds * float64(time.Second))
+            if inOurGroup {
+                ours.CrawlDelay = delay
+            } else if inWildcard {
+                wildcard.CrawlDelay = delay
+            }
+        }
+    }
+
+    if sawOurs {
+        return &ours
+    }
+    return &wildcard
+}
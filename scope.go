@@ -0,0 +1,175 @@
+package main
+
+// scope.go restricts which discovered links the crawler is willing to
+// follow. Without a Scope, startCrawling will walk every http(s) link it
+// finds anywhere on the web, which is rarely what's wanted outside of a
+// deliberately unbounded crawl.
+
+import (
+    "fmt"
+    "net/url"
+    "regexp"
+    "strings"
+
+    "golang.org/x/net/publicsuffix"
+)
+
+// Scope decides whether a discovered URL should be enqueued for crawling.
+type Scope interface {
+    InScope(u url.URL) bool
+}
+
+// AllScope allows every URL. It is the zero-configuration default and
+// matches the crawler's previous, unrestricted behavior.
+type AllScope struct{}
+
+func (AllScope) InScope(u url.URL) bool { return true }
+
+// SameHostScope only allows URLs whose host exactly matches the seed's.
+type SameHostScope struct {
+    Host string
+}
+
+func NewSameHostScope(seed url.URL) *SameHostScope {
+    return &SameHostScope{Host: strings.ToLower(seed.Hostname())}
+}
+
+func (s *SameHostScope) InScope(u url.URL) bool {
+    return strings.ToLower(u.Hostname()) == s.Host
+}
+
+// SameDomainScope allows URLs under the same registered domain (eTLD+1)
+// as the seed, so a seed of www.example.com also admits shop.example.com.
+type SameDomainScope struct {
+    Domain string
+}
+
+func NewSameDomainScope(seed url.URL) (*SameDomainScope, error) {
+    domain, err := publicsuffix.EffectiveTLDPlusOne(seed.Hostname())
+    if err != nil {
+        return nil, err
+    }
+    return &SameDomainScope{Domain: strings.ToLower(domain)}, nil
+}
+
+func (s *SameDomainScope) InScope(u url.URL) bool {
+    domain, err := publicsuffix.EffectiveTLDPlusOne(u.Hostname())
+    if err != nil {
+        return false
+    }
+    return strings.ToLower(domain) == s.Domain
+}
+
+// PrefixScope allows any URL whose string form starts with Prefix, e.g.
+// "https://example.com/docs/" to stay under a single section of a site.
+type PrefixScope struct {
+    Prefix string
+}
+
+func NewPrefixScope(prefix string) *PrefixScope {
+    return &PrefixScope{Prefix: prefix}
+}
+
+func (s *PrefixScope) InScope(u url.URL) bool {
+    return strings.HasPrefix(u.String(), s.Prefix)
+}
+
+// RegexScope allows any URL whose string form matches Pattern.
+type RegexScope struct {
+    Pattern *regexp.Regexp
+}
+
+func NewRegexScope(pattern string) (*RegexScope, error) {
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        return nil, fmt.Errorf("invalid -pattern %q: %w", pattern, err)
+    }
+    return &RegexScope{Pattern: re}, nil
+}
+
+func (s *RegexScope) InScope(u url.URL) bool {
+    return s.Pattern.MatchString(u.String())
+}
+
+// IncludeExcludeScope layers extra include/exclude regex filters on top of
+// a Base scope: a URL must satisfy Base (if set), match at least one
+// Include pattern (if any are given), and match no Exclude pattern.
+type IncludeExcludeScope struct {
+    Base    Scope
+    Include []*regexp.Regexp
+    Exclude []*regexp.Regexp
+}
+
+func (s *IncludeExcludeScope) InScope(u url.URL) bool {
+    if s.Base != nil && !s.Base.InScope(u) {
+        return false
+    }
+    str := u.String()
+    for _, re := range s.Exclude {
+        if re.MatchString(str) {
+            return false
+        }
+    }
+    if len(s.Include) == 0 {
+        return true
+    }
+    for _, re := range s.Include {
+        if re.MatchString(str) {
+            return true
+        }
+    }
+    return false
+}
+
+// stringSliceFlag collects repeated occurrences of a flag, e.g.
+// -include foo -include bar, into a slice.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+    return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+    *s = append(*s, value)
+    return nil
+}
+
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+    compiled := make([]*regexp.Regexp, 0, len(patterns))
+    for _, pattern := range patterns {
+        re, err := regexp.Compile(pattern)
+        if err != nil {
+            return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+        }
+        compiled = append(compiled, re)
+    }
+    return compiled, nil
+}
+
+// NewScope builds the Scope described by the -scope flag ("", "host",
+// "domain", "prefix" or "regex") for the given seed URL. prefix and
+// pattern are only consulted for the "prefix" and "regex" kinds
+// respectively: prefix defaults to the seed URL itself when empty, while
+// pattern is required.
+func NewScope(kind string, seed url.URL, prefix string, pattern string) (Scope, error) {
+    switch kind {
+    case "", "none":
+        return AllScope{}, nil
+    case "host":
+        return NewSameHostScope(seed), nil
+    case "domain":
+        return NewSameDomainScope(seed)
+    case "prefix":
+        if prefix == "" {
+            prefix = seed.String()
+        }
+        return NewPrefixScope(prefix), nil
+    case "regex":
+        if pattern == "" {
+            return nil, fmt.Errorf("-scope=regex requires -pattern")
+        }
+        return NewRegexScope(pattern)
+    default:
+        return nil, fmt.Errorf("unknown -scope %q, want one of: host, domain, prefix, regex, none", kind)
+    }
+}
@@ -0,0 +1,297 @@
+package main
+
+// sink.go replaces the old hardcoded storeResult function with a
+// pluggable ResultSink interface, so crawled pages can be indexed into
+// Elasticsearch, appended to a JSON Lines file, archived as WARC, or
+// printed to stdout, in any combination selected by -sink.
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+)
+
+// ResultSink receives every successfully crawled page.
+type ResultSink interface {
+    Store(ctx context.Context, result IndexedWebPage) error
+    Close() error
+}
+
+// SinkConfig carries the settings every sink kind might need; only the
+// fields relevant to the sinks actually selected are used.
+type SinkConfig struct {
+    ElasticsearchURL      string
+    ElasticsearchIndex    string
+    ElasticsearchUsername string
+    ElasticsearchPassword string
+    JSONLinesPath         string
+    WARCPath              string
+}
+
+// NewResultSink builds the sinks named in the comma-separated kinds
+// string (e.g. "es,jsonl") and combines them into one ResultSink.
+func NewResultSink(kinds string, config SinkConfig) (ResultSink, error) {
+    var sinks []ResultSink
+    for _, kind := range strings.Split(kinds, ",") {
+        kind = strings.TrimSpace(kind)
+        switch kind {
+        case "":
+            continue
+        case "es":
+            sinks = append(sinks, NewElasticsearchSink(config.ElasticsearchURL, config.ElasticsearchIndex, config.ElasticsearchUsername, config.ElasticsearchPassword))
+        case "jsonl":
+            sink, err := NewJSONLinesSink(config.JSONLinesPath)
+            if err != nil {
+                return nil, err
+            }
+            sinks = append(sinks, sink)
+        case "warc":
+            sink, err := NewWARCSink(config.WARCPath)
+            if err != nil {
+                return nil, err
+            }
+            sinks = append(sinks, sink)
+        case "stdout":
+            sinks = append(sinks, StdoutSink{})
+        default:
+            return nil, fmt.Errorf("unknown -sink %q, want any of: es, jsonl, warc, stdout", kind)
+        }
+    }
+    return &MultiSink{Sinks: sinks}, nil
+}
+
+// MultiSink fans a result out to every sink it wraps. It stores to all of
+// them even if one fails, returning the first error encountered so a
+// broken sink doesn't silently stop the others from receiving results.
+type MultiSink struct {
+    Sinks []ResultSink
+}
+
+func (m *MultiSink) Store(ctx context.Context, result IndexedWebPage) error {
+    var firstErr error
+    for _, sink := range m.Sinks {
+        if err := sink.Store(ctx, result); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
+
+func (m *MultiSink) Close() error {
+    var firstErr error
+    for _, sink := range m.Sinks {
+        if err := sink.Close(); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
+
+// StdoutSink prints each result as a line of JSON to stdout.
+type StdoutSink struct{}
+
+func (StdoutSink) Store(ctx context.Context, result IndexedWebPage) error {
+    body, err := json.Marshal(result)
+    if err != nil {
+        return err
+    }
+    _, err = fmt.Println(string(body))
+    return err
+}
+
+func (StdoutSink) Close() error { return nil }
+
+// JSONLinesSink appends one JSON object per crawled page to a file.
+type JSONLinesSink struct {
+    mu   sync.Mutex
+    file *os.File
+}
+
+func NewJSONLinesSink(path string) (*JSONLinesSink, error) {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+    if err != nil {
+        return nil, err
+    }
+    return &JSONLinesSink{file: f}, nil
+}
+
+func (s *JSONLinesSink) Store(ctx context.Context, result IndexedWebPage) error {
+    body, err := json.Marshal(result)
+    if err != nil {
+        return err
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    _, err = s.file.Write(append(body, '\n'))
+    return err
+}
+
+func (s *JSONLinesSink) Close() error {
+    return s.file.Close()
+}
+
+// WARCSink adapts a WarcWriter to the ResultSink interface.
+type WARCSink struct {
+    writer *WarcWriter
+}
+
+func NewWARCSink(path string) (*WARCSink, error) {
+    w, err := NewWarcWriter(path)
+    if err != nil {
+        return nil, err
+    }
+    return &WARCSink{writer: w}, nil
+}
+
+func (s *WARCSink) Store(ctx context.Context, result IndexedWebPage) error {
+    return s.writer.WriteResult(result)
+}
+
+func (s *WARCSink) Close() error {
+    return s.writer.Close()
+}
+
+// Bulk batching parameters for ElasticsearchSink: flush after whichever
+// of these limits is hit first.
+const (
+    bulkMaxDocs  = 100
+    bulkMaxBytes = 5 * 1024 * 1024
+)
+
+// ElasticsearchSink indexes results into Elasticsearch via the _bulk
+// API, batching documents instead of issuing one PUT per page like the
+// crawler used to.
+type ElasticsearchSink struct {
+    url      string
+    index    string
+    username string
+    password string
+    client   *http.Client
+
+    mu   sync.Mutex
+    buf  bytes.Buffer
+    docs int
+}
+
+func NewElasticsearchSink(url, index, username, password string) *ElasticsearchSink {
+    return &ElasticsearchSink{
+        url:      strings.TrimRight(url, "/"),
+        index:    index,
+        username: username,
+        password: password,
+        client:   &http.Client{},
+    }
+}
+
+func (s *ElasticsearchSink) Store(ctx context.Context, result IndexedWebPage) error {
+    body, err := json.Marshal(result)
+    if err != nil {
+        return err
+    }
+    action, err := json.Marshal(map[string]interface{}{
+        "index": map[string]string{"_index": s.index, "_id": result.URL.String()},
+    })
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    s.buf.Write(action)
+    s.buf.WriteByte('\n')
+    s.buf.Write(body)
+    s.buf.WriteByte('\n')
+    s.docs++
+    flush := s.docs >= bulkMaxDocs || s.buf.Len() >= bulkMaxBytes
+    s.mu.Unlock()
+
+    if flush {
+        return s.Flush(ctx)
+    }
+    return nil
+}
+
+// Flush sends any buffered documents to the _bulk endpoint right away,
+// without waiting for a batch to fill.
+func (s *ElasticsearchSink) Flush(ctx context.Context) error {
+    s.mu.Lock()
+    if s.buf.Len() == 0 {
+        s.mu.Unlock()
+        return nil
+    }
+    payload := make([]byte, s.buf.Len())
+    copy(payload, s.buf.Bytes())
+    s.buf.Reset()
+    s.docs = 0
+    s.mu.Unlock()
+
+    req, err := http.NewRequestWithContext(ctx, "POST", s.url+"/_bulk", bytes.NewReader(payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/x-ndjson")
+    if s.username != "" {
+        req.SetBasicAuth(s.username, s.password)
+    }
+
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return fmt.Errorf("reading elasticsearch bulk response: %w", err)
+    }
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("elasticsearch bulk request failed: %s: %s", resp.Status, respBody)
+    }
+    return reportBulkItemErrors(respBody)
+}
+
+// bulkResponse is the subset of the Elasticsearch _bulk response needed to
+// detect per-item failures: the endpoint answers HTTP 200 even when some
+// documents failed to index (mapping conflicts, version conflicts, ...),
+// so the top-level status alone can't be trusted.
+type bulkResponse struct {
+    Errors bool                       `json:"errors"`
+    Items  []map[string]bulkItemError `json:"items"`
+}
+
+type bulkItemError struct {
+    Status int             `json:"status"`
+    Error  json.RawMessage `json:"error"`
+}
+
+// reportBulkItemErrors logs every failed item in a _bulk response body and
+// returns an error summarizing how many documents failed to index.
+func reportBulkItemErrors(body []byte) error {
+    var parsed bulkResponse
+    if err := json.Unmarshal(body, &parsed); err != nil {
+        return fmt.Errorf("parsing elasticsearch bulk response: %w", err)
+    }
+    if !parsed.Errors {
+        return nil
+    }
+    failed := 0
+    for _, item := range parsed.Items {
+        for action, result := range item {
+            if result.Error == nil {
+                continue
+            }
+            failed++
+            log.Printf("elasticsearch bulk %s failed (status %d): %s\n", action, result.Status, result.Error)
+        }
+    }
+    return fmt.Errorf("elasticsearch bulk request reported %d failed item(s)", failed)
+}
+
+func (s *ElasticsearchSink) Close() error {
+    return s.Flush(context.Background())
+}
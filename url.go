@@ -0,0 +1,174 @@
+package main
+
+// url.go resolves in-page references against a base URL and normalizes
+// URLs before they're used as seen-set keys, so equivalent URLs (scheme
+// case, default ports, trailing fragments, tracking parameters, ...)
+// don't get crawled more than once.
+
+import (
+    "errors"
+    "net"
+    "net/url"
+    "path"
+    "sort"
+    "strings"
+)
+
+// resolveHref resolves href against base using the standard reference
+// resolution algorithm (RFC 3986 section 5), which correctly handles
+// scheme-relative references ("//host/path"), "."/".." segments,
+// query-only references ("?x=1") and empty paths - all cases the
+// previous hand-rolled implementation got wrong.
+func resolveHref(base url.URL, href string) (*url.URL, error) {
+    if base.Scheme == "" || base.Host == "" {
+        return nil, errors.New("base URL has no scheme / host")
+    }
+    ref, err := url.Parse(href)
+    if err != nil {
+        return nil, err
+    }
+    return base.ResolveReference(ref), nil
+}
+
+// trackingQueryParams are parameters added by analytics/ad tooling that
+// don't change what a URL identifies; they're stripped during
+// normalization so e.g. "?utm_source=x" doesn't produce a distinct key
+// from the same URL without it.
+var trackingQueryParams = map[string]bool{
+    "fbclid": true,
+    "gclid":  true,
+}
+
+func isTrackingQueryParam(key string) bool {
+    key = strings.ToLower(key)
+    return trackingQueryParams[key] || strings.HasPrefix(key, "utm_")
+}
+
+// NormalizeURL returns a canonical form of u suitable for use as a
+// dedupe key: lowercased scheme/host, no default port, no fragment,
+// "."/".." path segments resolved, unreserved percent-escapes decoded,
+// tracking query parameters stripped, and remaining query parameters
+// sorted by key.
+func NormalizeURL(u url.URL) url.URL {
+    u.Scheme = strings.ToLower(u.Scheme)
+    u.Host = stripDefaultPort(u.Scheme, strings.ToLower(u.Host))
+    u.Fragment = ""
+    u.RawFragment = ""
+
+    // Work on the escaped form so percent-escapes are visible to decode,
+    // then derive Path back from it so url.URL.String() reflects the
+    // normalized escaping rather than the original RawPath.
+    rawPath := normalizePath(decodeUnreservedEscapes(u.EscapedPath()))
+    if decoded, err := url.PathUnescape(rawPath); err == nil {
+        u.Path = decoded
+    }
+    u.RawPath = rawPath
+
+    u.RawQuery = normalizeQuery(u.RawQuery)
+    return u
+}
+
+func stripDefaultPort(scheme, host string) string {
+    h, port, err := net.SplitHostPort(host)
+    if err != nil {
+        return host
+    }
+    if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+        return h
+    }
+    return host
+}
+
+func normalizePath(p string) string {
+    if p == "" {
+        return "/"
+    }
+    cleaned := path.Clean(p)
+    if cleaned != "/" && strings.HasSuffix(p, "/") {
+        cleaned += "/"
+    }
+    return cleaned
+}
+
+func normalizeQuery(rawQuery string) string {
+    values, err := url.ParseQuery(rawQuery)
+    if err != nil {
+        return rawQuery
+    }
+    for key := range values {
+        if isTrackingQueryParam(key) {
+            delete(values, key)
+        }
+    }
+    if len(values) == 0 {
+        return ""
+    }
+
+    keys := make([]string, 0, len(values))
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    var b strings.Builder
+    for i, key := range keys {
+        for j, value := range values[key] {
+            if i > 0 || j > 0 {
+                b.WriteByte('&')
+            }
+            b.WriteString(url.QueryEscape(key))
+            b.WriteByte('=')
+            b.WriteString(url.QueryEscape(value))
+        }
+    }
+    return b.String()
+}
+
+// decodeUnreservedEscapes decodes percent-escapes that encode an
+// RFC 3986 "unreserved" character (ALPHA / DIGIT / "-" / "." / "_" /
+// "~"), leaving every other escape (including malformed ones) alone, so
+// e.g. "%7Eabc" and "~abc" normalize to the same path but "%2F" (an
+// encoded path separator) is not folded into "/".
+func decodeUnreservedEscapes(s string) string {
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        if s[i] == '%' && i+2 < len(s) {
+            hi, okHi := hexValue(s[i+1])
+            lo, okLo := hexValue(s[i+2])
+            if okHi && okLo {
+                decoded := byte(hi<<4 | lo)
+                if isUnreserved(decoded) {
+                    b.WriteByte(decoded)
+                    i += 2
+                    continue
+                }
+            }
+        }
+        b.WriteByte(s[i])
+    }
+    return b.String()
+}
+
+func hexValue(c byte) (byte, bool) {
+    switch {
+    case c >= '0' && c <= '9':
+        return c - '0', true
+    case c >= 'a' && c <= 'f':
+        return c - 'a' + 10, true
+    case c >= 'A' && c <= 'F':
+        return c - 'A' + 10, true
+    default:
+        return 0, false
+    }
+}
+
+func isUnreserved(c byte) bool {
+    switch {
+    case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+        return true
+    case c == '-' || c == '.' || c == '_' || c == '~':
+        return true
+    default:
+        return false
+    }
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+    "net/url"
+    "testing"
+)
+
+// The reference-resolution cases below are the "normal" and "abnormal"
+// examples from RFC 3986 section 5.4, against the fixed base URI
+// "http://a/b/c/d;p?q".
+func TestResolveHref(t *testing.T) {
+    base, err := url.Parse("http://a/b/c/d;p?q")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cases := []struct {
+        ref  string
+        want string
+    }{
+        // 5.4.1. Normal Examples
+        {"g:h", "g:h"},
+        {"g", "http://a/b/c/g"},
+        {"./g", "http://a/b/c/g"},
+        {"g/", "http://a/b/c/g/"},
+        {"/g", "http://a/g"},
+        {"//g", "http://g"},
+        {"?y", "http://a/b/c/d;p?y"},
+        {"g?y", "http://a/b/c/g?y"},
+        {"#s", "http://a/b/c/d;p?q#s"},
+        {"g#s", "http://a/b/c/g#s"},
+        {"g?y#s", "http://a/b/c/g?y#s"},
+        {";x", "http://a/b/c/;x"},
+        {"g;x", "http://a/b/c/g;x"},
+        {"g;x?y#s", "http://a/b/c/g;x?y#s"},
+        {"", "http://a/b/c/d;p?q"},
+        {".", "http://a/b/c/"},
+        {"./", "http://a/b/c/"},
+        {"..", "http://a/b/"},
+        {"../", "http://a/b/"},
+        {"../g", "http://a/b/g"},
+        {"../..", "http://a/"},
+        {"../../", "http://a/"},
+        {"../../g", "http://a/g"},
+
+        // 5.4.2. Abnormal Examples
+        {"../../../g", "http://a/g"},
+        {"../../../../g", "http://a/g"},
+        {"/./g", "http://a/g"},
+        {"/../g", "http://a/g"},
+        {"g.", "http://a/b/c/g."},
+        {".g", "http://a/b/c/.g"},
+        {"g..", "http://a/b/c/g.."},
+        {"..g", "http://a/b/c/..g"},
+        {"./../g", "http://a/b/g"},
+        {"./g/.", "http://a/b/c/g/"},
+        {"g/./h", "http://a/b/c/g/h"},
+        {"g/../h", "http://a/b/c/h"},
+        {"g;x=1/./y", "http://a/b/c/g;x=1/y"},
+        {"g;x=1/../y", "http://a/b/c/y"},
+        {"g?y/./x", "http://a/b/c/g?y/./x"},
+        {"g?y/../x", "http://a/b/c/g?y/../x"},
+        {"g#s/./x", "http://a/b/c/g#s/./x"},
+        {"g#s/../x", "http://a/b/c/g#s/../x"},
+    }
+
+    for _, c := range cases {
+        t.Run(c.ref, func(t *testing.T) {
+            got, err := resolveHref(*base, c.ref)
+            if err != nil {
+                t.Fatalf("resolveHref(%q) error: %v", c.ref, err)
+            }
+            if got.String() != c.want {
+                t.Errorf("resolveHref(%q) = %q, want %q", c.ref, got.String(), c.want)
+            }
+        })
+    }
+}
+
+func TestResolveHrefNoBase(t *testing.T) {
+    _, err := resolveHref(url.URL{}, "/g")
+    if err == nil {
+        t.Fatal("expected an error resolving against a base URL with no scheme/host")
+    }
+}
+
+func TestNormalizeURL(t *testing.T) {
+    cases := []struct {
+        name string
+        in   string
+        want string
+    }{
+        {"lowercases scheme and host", "HTTP://EX.com/a", "http://ex.com/a"},
+        {"strips default http port", "http://ex.com:80/a", "http://ex.com/a"},
+        {"strips default https port", "https://ex.com:443/a", "https://ex.com/a"},
+        {"keeps non-default port", "http://ex.com:8080/a", "http://ex.com:8080/a"},
+        {"strips fragment", "http://ex.com/a#top", "http://ex.com/a"},
+        {"resolves dot segments", "http://ex.com/a/../b", "http://ex.com/b"},
+        {"decodes unreserved escapes", "http://ex.com/%7Eabc", "http://ex.com/~abc"},
+        {"keeps reserved escapes", "http://ex.com/a%2Fb", "http://ex.com/a%2Fb"},
+        {"sorts query parameters", "http://ex.com/a?b=2&a=1", "http://ex.com/a?a=1&b=2"},
+        {"strips utm_ params", "http://ex.com/a?utm_source=x&id=1", "http://ex.com/a?id=1"},
+        {"strips fbclid and gclid", "http://ex.com/a?fbclid=1&gclid=2&id=3", "http://ex.com/a?id=3"},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func(t *testing.T) {
+            u, err := url.Parse(c.in)
+            if err != nil {
+                t.Fatal(err)
+            }
+            got := NormalizeURL(*u)
+            if got.String() != c.want {
+                t.Errorf("NormalizeURL(%q) = %q, want %q", c.in, got.String(), c.want)
+            }
+        })
+    }
+}
+
+func TestNormalizeURLDedupeKey(t *testing.T) {
+    a, _ := url.Parse("http://ex.com/a")
+    b, _ := url.Parse("http://EX.com:80/a?utm_source=x#top")
+
+    na := NormalizeURL(*a)
+    nb := NormalizeURL(*b)
+    if na.String() != nb.String() {
+        t.Errorf("expected equivalent URLs to normalize the same: %q != %q", na.String(), nb.String())
+    }
+}
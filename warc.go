@@ -0,0 +1,142 @@
+package main
+
+// Minimal writer for the WARC/1.0 format (ISO 28500), good enough to
+// produce archives that pywb, the Internet Archive's CDX tools and other
+// Heritrix-compatible consumers can read. Each record is written as its
+// own gzip member, as required by the spec, so a reader can seek to and
+// decompress a single record without unpacking the whole file.
+
+import (
+    "bytes"
+    "compress/gzip"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/base32"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+// WarcWriter appends WARC records to a single output file. It is safe for
+// concurrent use by multiple crawler goroutines.
+type WarcWriter struct {
+    mu   sync.Mutex
+    file *os.File
+}
+
+// NewWarcWriter creates (or truncates) the file at path and writes the
+// leading "warcinfo" record describing this crawl.
+func NewWarcWriter(path string) (*WarcWriter, error) {
+    f, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+    w := &WarcWriter{file: f}
+    if err := w.writeWarcinfo(); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return w, nil
+}
+
+func (w *WarcWriter) Close() error {
+    return w.file.Close()
+}
+
+func newWarcRecordID() string {
+    var b [16]byte
+    if _, err := rand.Read(b[:]); err != nil {
+        panic(err) // crypto/rand failing is not something we can recover from
+    }
+    // Version 4, variant 10xxxxxx, per RFC 4122.
+    b[6] = (b[6] & 0x0f) | 0x40
+    b[8] = (b[8] & 0x3f) | 0x80
+    return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func (w *WarcWriter) writeWarcinfo() error {
+    body := []byte("software: kraul\r\nformat: WARC File Format 1.0\r\n")
+    header := map[string]string{
+        "WARC-Type":       "warcinfo",
+        "WARC-Record-ID":  newWarcRecordID(),
+        "WARC-Date":       time.Now().UTC().Format(time.RFC3339),
+        "Content-Type":    "application/warc-fields",
+        "Content-Length":  fmt.Sprintf("%d", len(body)),
+    }
+    return w.writeRecord(header, body)
+}
+
+// writeRecord gzip-compresses a single WARC record (header block + body)
+// and appends it to the file.
+func (w *WarcWriter) writeRecord(header map[string]string, body []byte) error {
+    var rec bytes.Buffer
+    rec.WriteString("WARC/1.0\r\n")
+    for _, key := range []string{"WARC-Type", "WARC-Record-ID", "WARC-Date", "WARC-Target-URI", "WARC-Concurrent-To", "WARC-Payload-Digest", "Content-Type", "Content-Length"} {
+        if value, ok := header[key]; ok {
+            fmt.Fprintf(&rec, "%s: %s\r\n", key, value)
+        }
+    }
+    rec.WriteString("\r\n")
+    rec.Write(body)
+    rec.WriteString("\r\n\r\n")
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    gz := gzip.NewWriter(w.file)
+    if _, err := gz.Write(rec.Bytes()); err != nil {
+        gz.Close()
+        return err
+    }
+    return gz.Close()
+}
+
+// payloadDigest returns the "sha1:<base32>" digest WARC uses for
+// WARC-Payload-Digest, computed over the HTTP payload only (the bytes
+// after the header block's blank line).
+func payloadDigest(rawMessage []byte) string {
+    payload := rawMessage
+    if i := bytes.Index(rawMessage, []byte("\r\n\r\n")); i >= 0 {
+        payload = rawMessage[i+4:]
+    }
+    sum := sha1.Sum(payload)
+    return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// WriteResult archives one fetched page as a "request" record followed by
+// its concurrent "response" record.
+func (w *WarcWriter) WriteResult(result IndexedWebPage) error {
+    targetURI := result.URL.String()
+    responseID := newWarcRecordID()
+    requestID := newWarcRecordID()
+    date := result.FetchedAt.Format(time.RFC3339)
+
+    if len(result.RawRequest) > 0 {
+        err := w.writeRecord(map[string]string{
+            "WARC-Type":          "request",
+            "WARC-Record-ID":     requestID,
+            "WARC-Date":          date,
+            "WARC-Target-URI":    targetURI,
+            "WARC-Concurrent-To": responseID,
+            "Content-Type":       "application/http; msgtype=request",
+            "Content-Length":     fmt.Sprintf("%d", len(result.RawRequest)),
+        }, result.RawRequest)
+        if err != nil {
+            return err
+        }
+    }
+
+    if len(result.RawResponse) == 0 {
+        return nil
+    }
+    return w.writeRecord(map[string]string{
+        "WARC-Type":           "response",
+        "WARC-Record-ID":      responseID,
+        "WARC-Date":           date,
+        "WARC-Target-URI":     targetURI,
+        "WARC-Concurrent-To":  requestID,
+        "WARC-Payload-Digest": payloadDigest(result.RawResponse),
+        "Content-Type":        "application/http; msgtype=response",
+        "Content-Length":      fmt.Sprintf("%d", len(result.RawResponse)),
+    }, result.RawResponse)
+}